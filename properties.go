@@ -2,9 +2,19 @@
 package properties
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // This structure represents a mapping of keys to values.
@@ -12,17 +22,33 @@ import (
 // The property keys and values are represented as string objects.
 type Properties struct {
 	values map[string]string
+	// When set, an unescaped equals sign in a property value is rejected by Load instead of
+	// being taken literally, and Store escapes the equals signs in values accordingly.
+	StrictValueSeparator bool
+	// When not empty, assigning this value to a property (through Set, Load or a merge)
+	// removes the property instead of storing the value.
+	// This allows an overriding layer of configuration to unset a property defined in a lower one.
+	// DefaultUnsetSentinel is the suggested value.
+	UnsetSentinel string
 }
 
+// Suggested value for the UnsetSentinel field of Properties.
+const DefaultUnsetSentinel = "__unset__"
+
 // Create an empty instance of the Properties structure.
 func New() *Properties {
-	return &Properties{make(map[string]string)}
+	return &Properties{values: make(map[string]string)}
 }
 
 // Assign the given value to the property with the specified key.
 // If no property with this key exists, it is added;
 // otherwise, the value is replaced by the one given and the former value is discarded.
+// If the value is the UnsetSentinel of the instance, the property is removed instead.
 func (p *Properties) Set(key string, value string) {
+	if p.UnsetSentinel != "" && value == p.UnsetSentinel {
+		delete(p.values, key)
+		return
+	}
 	p.values[key] = value
 }
 
@@ -33,6 +59,153 @@ func (p *Properties) Get(key string) (string, bool) {
 	return val, present
 }
 
+// Retrieve the value of the property with the specified key, split into tokens.
+// Tokens are delimited by any run of commas and whitespace, and empty tokens are dropped:
+// "auth, logging  metrics" gives the tokens "auth", "logging" and "metrics".
+// If there is no property with this key, nil is returned.
+func (p *Properties) GetTokens(key string) ([]string, bool) {
+	val, present := p.values[key]
+	if !present {
+		return nil, false
+	}
+	return strings.FieldsFunc(val, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}), true
+}
+
+// Return a point-in-time copy of the properties.
+// Subsequent modifications of either instance are not reflected in the other,
+// so the copy can be iterated over freely while the original keeps being updated.
+// Note that Properties is not synchronized: the snapshot itself must not be taken
+// while another goroutine modifies the instance.
+func (p *Properties) Snapshot() *Properties {
+	snapshot := *p
+	snapshot.values = maps.Clone(p.values)
+	return &snapshot
+}
+
+// Copy all the properties of other into p, replacing the values of the keys defined in both.
+// Return the keys defined in both instances with different values, mapped to the pair
+// of their former value in p and their new value taken from other.
+func (p *Properties) MergeReport(other *Properties) (conflicts map[string][2]string) {
+	conflicts = make(map[string][2]string)
+	for key, val := range other.values {
+		if oldVal, present := p.values[key]; present && oldVal != val {
+			conflicts[key] = [2]string{oldVal, val}
+		}
+		p.Set(key, val)
+	}
+	return conflicts
+}
+
+type missingPropError struct {
+	key string
+}
+
+func (e missingPropError) Error() string {
+	return fmt.Sprintf("no property with key %q", e.key)
+}
+
+// Retrieve the value of the property with the specified key, expressed as a percentage,
+// and return it as a fraction (e.g. "25%" gives 0.25).
+// The value must consist of a number followed by a percent sign;
+// values without the percent sign are rejected, to avoid any ambiguity between "0.25" and "25".
+// An error is returned if there is no property with this key or if its value is malformed.
+func (p *Properties) GetPercent(key string) (float64, error) {
+	val, present := p.values[key]
+	if !present {
+		return 0, missingPropError{key}
+	}
+	number, found := strings.CutSuffix(val, "%")
+	if !found {
+		return 0, fmt.Errorf("property %q: missing percent sign in %q", key, val)
+	}
+	// ParseFloat also accepts hexadecimal notation, infinities and NaN, none of which make sense here
+	if strings.ContainsAny(number, "xX") {
+		return 0, fmt.Errorf("property %q: malformed percentage %q", key, val)
+	}
+	percent, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("property %q: %w", key, err)
+	}
+	if math.IsNaN(percent) || math.IsInf(percent, 0) {
+		return 0, fmt.Errorf("property %q: malformed percentage %q", key, val)
+	}
+	return percent / 100, nil
+}
+
+// Retrieve the value of the property with the specified key as an integer count of the given unit
+func (p *Properties) getDuration(key string, unit time.Duration) (time.Duration, error) {
+	val, present := p.values[key]
+	if !present {
+		return 0, missingPropError{key}
+	}
+	count, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("property %q: %w", key, err)
+	}
+	if count > math.MaxInt64/int64(unit) || count < math.MinInt64/int64(unit) {
+		return 0, fmt.Errorf("property %q: duration %q out of range", key, val)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// Retrieve the value of the property with the specified key, as a whole number of milliseconds.
+// An error is returned if there is no property with this key or if its value is not an integer.
+func (p *Properties) GetMillis(key string) (time.Duration, error) {
+	return p.getDuration(key, time.Millisecond)
+}
+
+// Retrieve the value of the property with the specified key, as a whole number of seconds.
+// An error is returned if there is no property with this key or if its value is not an integer.
+func (p *Properties) GetSeconds(key string) (time.Duration, error) {
+	return p.getDuration(key, time.Second)
+}
+
+// Canonical charset names, indexed by their normalized aliases
+var charsets = map[string]string{
+	"utf8":        "UTF-8",
+	"utf16":       "UTF-16",
+	"utf16be":     "UTF-16BE",
+	"utf16le":     "UTF-16LE",
+	"usascii":     "US-ASCII",
+	"ascii":       "US-ASCII",
+	"iso88591":    "ISO-8859-1",
+	"latin1":      "ISO-8859-1",
+	"l1":          "ISO-8859-1",
+	"iso885915":   "ISO-8859-15",
+	"latin9":      "ISO-8859-15",
+	"windows1252": "Windows-1252",
+	"cp1252":      "Windows-1252",
+}
+
+// Used to normalize charset names before looking them up in charsets
+var charsetNormalizer = strings.NewReplacer("-", "", "_", "")
+
+// Retrieve the value of the property with the specified key, as the canonical name of a charset.
+// The supported charsets are, with their accepted aliases:
+//   - UTF-8 (utf8)
+//   - UTF-16, UTF-16BE and UTF-16LE
+//   - US-ASCII (ascii)
+//   - ISO-8859-1 (latin1, l1)
+//   - ISO-8859-15 (latin9)
+//   - Windows-1252 (cp1252)
+//
+// Names are matched regardless of case, hyphens and underscores (e.g. "Latin-1" or "utf_8").
+// An error is returned if there is no property with this key or if the charset is not supported.
+func (p *Properties) GetCharset(key string) (string, error) {
+	val, present := p.values[key]
+	if !present {
+		return "", missingPropError{key}
+	}
+	normalized := charsetNormalizer.Replace(strings.ToLower(val))
+	charset, found := charsets[normalized]
+	if !found {
+		return "", fmt.Errorf("property %q: unsupported charset %q", key, val)
+	}
+	return charset, nil
+}
+
 type propDefError struct {
 	lineNumber uint
 	message    string
@@ -118,6 +291,8 @@ func processByte(c byte, p *Properties, state *loadState) error {
 		state.builder.Reset()
 		state.inKey = false
 		state.inMember = false
+	case c == '=' && p.StrictValueSeparator:
+		return propDefError{state.lineNumber, "unescaped separator in value"}
 	case !state.inMember && state.inKey && c == '#':
 		// (!state.inMember && state.inKey) <=> at the beginning of the line (index 0 or in indentation whitespace)
 		state.skipLine = true
@@ -129,6 +304,22 @@ func processByte(c byte, p *Properties, state *loadState) error {
 	return nil
 }
 
+// Handle the end of the input, once all bytes have been processed
+func processEndOfInput(p *Properties, state *loadState) error {
+	if state.escaped {
+		return propDefError{state.lineNumber, "line wrapped without a continuation"}
+	}
+	// Process last line if no trailing EOL was found
+	if state.inMember {
+		if state.inKey {
+			// No separator found: ill-formed definition
+			return propDefError{state.lineNumber, "no separator"}
+		}
+		p.Set(strings.TrimRight(state.key, " \t"), strings.TrimRight(state.builder.String(), " \t"))
+	}
+	return nil
+}
+
 // Parse properties in text form from the given reader.
 func (p *Properties) Load(reader io.Reader) error {
 	buffer := make([]byte, 1)
@@ -142,16 +333,8 @@ func (p *Properties) Load(reader io.Reader) error {
 			return err
 		}
 	}
-	if state.escaped {
-		return propDefError{state.lineNumber, "line wrapped without a continuation"}
-	}
-	// Process last line if no trailing EOL was found
-	if state.inMember {
-		if state.inKey {
-			// No separator found: ill-formed definition
-			return propDefError{state.lineNumber, "no separator"}
-		}
-		p.Set(strings.TrimRight(state.key, " \t"), strings.TrimRight(state.builder.String(), " \t"))
+	if err := processEndOfInput(p, &state); err != nil {
+		return err
 	}
 	if err == io.EOF {
 		return nil
@@ -159,23 +342,273 @@ func (p *Properties) Load(reader io.Reader) error {
 	return err
 }
 
+// Parse properties in text form from the given reader directly into the given map,
+// overwriting the values of the keys already present.
+// The parsing is identical to that of Load. The map must not be nil.
+func LoadInto(m map[string]string, reader io.Reader) error {
+	if m == nil {
+		return errors.New("cannot load properties into a nil map")
+	}
+	p := Properties{values: m}
+	return p.Load(reader)
+}
+
+// Returned by LoadUntil when the end of the input is reached before the terminator line.
+var ErrNoTerminator = errors.New("end of input reached before the terminator line")
+
+// Parse properties in text form from the given reader, until a line exactly equal to the
+// given terminator is met.
+// The terminator line is not parsed as a property definition, and nothing past it is read
+// from the reader, which can then be used to process the rest of the input.
+// A line that continues a wrapped line is never considered as the terminator.
+// If the end of the input is reached before the terminator, the properties read are kept and
+// ErrNoTerminator is returned; callers for whom the terminator is optional can ignore it.
+func (p *Properties) LoadUntil(reader io.Reader, terminator string) error {
+	buffer := make([]byte, 1)
+	state := loadState{
+		lineNumber: 1,
+		inKey:      true,
+	}
+	// Bytes of the current physical line, processed once it is known not to be the terminator
+	var line []byte
+	// Indicates whether the current physical line continues a wrapped line
+	wrapped := false
+	var err error
+	for _, err = reader.Read(buffer); err == nil; _, err = reader.Read(buffer) {
+		if buffer[0] != '\n' {
+			line = append(line, buffer[0])
+			continue
+		}
+		if !wrapped && string(line) == terminator {
+			return nil
+		}
+		for _, c := range line {
+			if err := processByte(c, p, &state); err != nil {
+				return err
+			}
+		}
+		wrapped = state.escaped
+		if err := processByte('\n', p, &state); err != nil {
+			return err
+		}
+		line = line[:0]
+	}
+	if err != io.EOF {
+		return err
+	}
+	if len(line) > 0 && !wrapped && string(line) == terminator {
+		return nil
+	}
+	for _, c := range line {
+		if err := processByte(c, p, &state); err != nil {
+			return err
+		}
+	}
+	if err := processEndOfInput(p, &state); err != nil {
+		return err
+	}
+	return ErrNoTerminator
+}
+
+var (
+	keyEscaper   = strings.NewReplacer("=", "\\=", "\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+	valueEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+	// Used instead of valueEscaper in strict mode, to produce values that can be read back
+	strictValueEscaper = strings.NewReplacer("=", "\\=", "\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+)
+
+func (p *Properties) getValueEscaper() *strings.Replacer {
+	if p.StrictValueSeparator {
+		return strictValueEscaper
+	}
+	return valueEscaper
+}
+
 // Output the properties in text form to the given writer.
+// The output is buffered, and flushed before returning.
 func (p *Properties) Store(writer io.Writer) error {
-	keyEscaper := strings.NewReplacer("=", "\\=", "\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t")
-	valueEscaper := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+	buffered := bufio.NewWriter(writer)
+	escaper := p.getValueEscaper()
 	for key, val := range p.values {
-		if _, e := keyEscaper.WriteString(writer, key); e != nil {
+		if _, e := keyEscaper.WriteString(buffered, key); e != nil {
 			return e
 		}
-		if _, e := writer.Write([]byte{'='}); e != nil {
+		if e := buffered.WriteByte('='); e != nil {
 			return e
 		}
-		if _, e := valueEscaper.WriteString(writer, val); e != nil {
+		if _, e := escaper.WriteString(buffered, val); e != nil {
 			return e
 		}
-		if _, e := writer.Write([]byte{'\n'}); e != nil {
+		if e := buffered.WriteByte('\n'); e != nil {
+			return e
+		}
+	}
+	return buffered.Flush()
+}
+
+// Split the escaped representation of a value over several lines, joined by line continuations,
+// so that no line exceeds the given width (in characters).
+// The column parameter is the position at which the value starts on the first line,
+// and indent the number of spaces prepended to continuation lines.
+func wrapValue(value string, column, indent, width int) string {
+	var wrapped strings.Builder
+	var line []string
+	for i := 0; i < len(value); {
+		n := 2
+		if value[i] != '\\' {
+			_, n = utf8.DecodeRuneInString(value[i:])
+		}
+		line = append(line, value[i:i+n])
+		column += utf8.RuneCountInString(value[i : i+n])
+		i += n
+		// Room must be kept for the trailing backslash, except on the last line
+		reserved := 1
+		if i == len(value) {
+			reserved = 0
+		}
+		if column+reserved <= width {
+			continue
+		}
+		// Load discards leading whitespace on continuation lines:
+		// the line can only be broken before a non-space character
+		j := len(line) - 1
+		for j > 0 && line[j][0] == ' ' {
+			j--
+		}
+		if j == 0 {
+			// No suitable break point, let the line overflow
+			continue
+		}
+		for _, token := range line[:j] {
+			wrapped.WriteString(token)
+		}
+		wrapped.WriteString("\\\n")
+		wrapped.WriteString(strings.Repeat(" ", indent))
+		line = line[j:]
+		column = indent
+		for _, token := range line {
+			column += utf8.RuneCountInString(token)
+		}
+	}
+	for _, token := range line {
+		wrapped.WriteString(token)
+	}
+	return wrapped.String()
+}
+
+// Output the properties in text form to the given writer,
+// wrapping long values so that no line exceeds the given width.
+// Continuation lines are indented to align with the start of the value, unless the key
+// takes up more than half of the width.
+// The wrapping is transparent to Load, which reconstructs the original values.
+// Keys are never wrapped, and a line may still exceed the width if it holds a long key
+// or a long run of spaces, since continuation lines cannot start with whitespace.
+// An error is returned if the width is not positive.
+func (p *Properties) StoreWrapped(writer io.Writer, width int) error {
+	if width <= 0 {
+		return fmt.Errorf("invalid line width %d", width)
+	}
+	for key, val := range p.values {
+		escapedKey := keyEscaper.Replace(key)
+		column := utf8.RuneCountInString(escapedKey) + 1
+		indent := min(column, width/2)
+		line := escapedKey + "=" + wrapValue(p.getValueEscaper().Replace(val), column, indent, width) + "\n"
+		if _, e := io.WriteString(writer, line); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Compare the properties of p against those of other.
+// Return the keys only defined in p, those only defined in other, and those defined in both
+// but to different values, each sorted in lexicographic order.
+func (p *Properties) diff(other *Properties) (added, removed, changed []string) {
+	for key, val := range p.values {
+		if otherVal, present := other.values[key]; !present {
+			added = append(added, key)
+		} else if otherVal != val {
+			changed = append(changed, key)
+		}
+	}
+	for key := range other.values {
+		if _, present := p.values[key]; !present {
+			removed = append(removed, key)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
+
+// Compare the properties against those defined in the file at the given path.
+// Return, sorted, the keys that are defined in the instance but not in the file,
+// those defined in the file but not in the instance, and those whose value differs.
+// An error is returned, annotated with the path, if the file cannot be opened or parsed.
+func (p *Properties) DriftFromFile(path string) (added, removed, changed []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+	onDisk := New()
+	if err := onDisk.Load(file); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	added, removed, changed = p.diff(onDisk)
+	return added, removed, changed, nil
+}
+
+var markdownEscaper = strings.NewReplacer("\\", "\\\\", "|", "\\|", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// Output the properties as a Markdown table with Key and Value columns, sorted by key.
+// Pipes and backslashes are escaped, and line breaks are rendered as HTML breaks.
+// This format is intended for documentation, and cannot be read back by Load.
+func (p *Properties) StoreMarkdownTable(writer io.Writer) error {
+	if _, e := io.WriteString(writer, "| Key | Value |\n| --- | --- |\n"); e != nil {
+		return e
+	}
+	for _, key := range slices.Sorted(maps.Keys(p.values)) {
+		row := "| " + markdownEscaper.Replace(key) + " | " + markdownEscaper.Replace(p.values[key]) + " |\n"
+		if _, e := io.WriteString(writer, row); e != nil {
 			return e
 		}
 	}
 	return nil
 }
+
+// Return the longest prefix, made of whole dot-separated segments, shared by all the keys.
+// The prefix includes the trailing dot, e.g. "myapp." if all keys start with "myapp.".
+// The last segment of a key is never part of the prefix, so that with a single key, the
+// prefix is that key up to and including its last dot.
+// The empty string is returned if the keys differ from their first segment,
+// or if there are no properties.
+func (p *Properties) CommonPrefix() string {
+	first := true
+	var prefix string
+	for key := range p.values {
+		if first {
+			prefix = key[:strings.LastIndexByte(key, '.')+1]
+			first = false
+		}
+		for !strings.HasPrefix(key, prefix) {
+			// Drop the last segment, keeping the dot before it
+			prefix = prefix[:strings.LastIndexByte(prefix[:len(prefix)-1], '.')+1]
+		}
+	}
+	return prefix
+}
+
+// Return the keys of all the properties whose value starts with the given prefix,
+// sorted in lexicographic order.
+func (p *Properties) KeysByValuePrefix(prefix string) []string {
+	var keys []string
+	for key, val := range p.values {
+		if strings.HasPrefix(val, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
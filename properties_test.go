@@ -1,8 +1,15 @@
 package properties
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -62,6 +69,13 @@ func storeToString(t *testing.T, prop *Properties) string {
 	return repr[:len(repr)-1] /* Trim trailing newline */
 }
 
+// A writer whose writes always fail
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
 func TestPropertiesGetReturnsValuePassedToSet(t *testing.T) {
 	assertSetAndGetBackSame(t, KEY, VALUE)
 }
@@ -215,3 +229,338 @@ func TestRoundTripLoadThenStore(t *testing.T) {
 		t.Fatal("Expected: " + repr + ", got: " + stored)
 	}
 }
+
+func TestPropertiesStoreWrappedRespectsWidth(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, strings.Repeat("a long value, ", 10))
+	stringWriter := &strings.Builder{}
+	if e := prop.StoreWrapped(stringWriter, 30); e != nil {
+		t.Fatal(e)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(stringWriter.String(), "\n"), "\n") {
+		if len(line) > 30 {
+			t.Fatalf("Line %q exceeds 30 characters", line)
+		}
+	}
+}
+
+func TestRoundTripStoreWrappedThenLoad(t *testing.T) {
+	prop := setUpTestInstance()
+	value := "value with  spaces\tand=special\\chars" + strings.Repeat(" repeated", 8)
+	prop.Set(KEY, value)
+	stringWriter := &strings.Builder{}
+	if e := prop.StoreWrapped(stringWriter, 12); e != nil {
+		t.Fatal(e)
+	}
+	prop2 := setUpTestInstance()
+	loadFromString(t, prop2, stringWriter.String())
+	assertGetExpected(t, prop2, KEY, value)
+}
+
+func TestPropertiesDriftFromFileReportsDifferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.properties")
+	if e := os.WriteFile(path, []byte("kept=value\nchanged=old\nremoved=value\n"), 0o644); e != nil {
+		t.Fatal(e)
+	}
+	prop := setUpTestInstance()
+	prop.Set("kept", "value")
+	prop.Set("changed", "new")
+	prop.Set("added", "value")
+	added, removed, changed, e := prop.DriftFromFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !slices.Equal(added, []string{"added"}) || !slices.Equal(removed, []string{"removed"}) ||
+		!slices.Equal(changed, []string{"changed"}) {
+		t.Fatalf("Unexpected drift: added %q, removed %q, changed %q", added, removed, changed)
+	}
+}
+
+func TestPropertiesDriftFromFileFailsOnMissingFile(t *testing.T) {
+	prop := setUpTestInstance()
+	if _, _, _, e := prop.DriftFromFile(filepath.Join(t.TempDir(), "missing.properties")); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesGetPercentReturnsFraction(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "25%")
+	if got, e := prop.GetPercent(KEY); e != nil || got != 0.25 {
+		t.Fatalf("Expected: 0.25; got %v (error: %v)", got, e)
+	}
+}
+
+func TestPropertiesGetPercentFailsWithoutPercentSign(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "0.25")
+	if _, e := prop.GetPercent(KEY); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesGetPercentFailsOnMissingKey(t *testing.T) {
+	prop := setUpTestInstance()
+	if _, e := prop.GetPercent(KEY); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesSnapshotIsIndependent(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, VALUE)
+	snapshot := prop.Snapshot()
+	prop.Set(KEY, "other value")
+	prop.Set("other key", VALUE)
+	assertGetExpected(t, snapshot, KEY, VALUE)
+	assertGetAbsent(t, snapshot, "other key")
+}
+
+func TestPropertiesLoadUntilStopsAtTerminator(t *testing.T) {
+	prop := setUpTestInstance()
+	reader := strings.NewReader(REPR + "\n.\nrest of the stream")
+	if e := prop.LoadUntil(reader, "."); e != nil {
+		t.Fatal(e)
+	}
+	assertGetExpected(t, prop, KEY, VALUE)
+	if rest, _ := io.ReadAll(reader); string(rest) != "rest of the stream" {
+		t.Fatalf("Expected: %q; got %q", "rest of the stream", rest)
+	}
+}
+
+func TestPropertiesLoadUntilIgnoresTerminatorInWrappedLine(t *testing.T) {
+	prop := setUpTestInstance()
+	if e := prop.LoadUntil(strings.NewReader(KEY+"=value\\\n.\n."), "."); e != nil {
+		t.Fatal(e)
+	}
+	assertGetExpected(t, prop, KEY, "value.")
+}
+
+func TestPropertiesLoadUntilFailsWithoutTerminator(t *testing.T) {
+	prop := setUpTestInstance()
+	if e := prop.LoadUntil(strings.NewReader(REPR+"\n"), "."); !errors.Is(e, ErrNoTerminator) {
+		t.Fatalf("Expected: %v; got %v", ErrNoTerminator, e)
+	}
+	assertGetExpected(t, prop, KEY, VALUE)
+}
+
+func TestPropertiesGetCharsetResolvesAliases(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "Latin_1")
+	if got, e := prop.GetCharset(KEY); e != nil || got != "ISO-8859-1" {
+		t.Fatalf("Expected: %q; got %q (error: %v)", "ISO-8859-1", got, e)
+	}
+}
+
+func TestPropertiesGetCharsetFailsOnUnknownCharset(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "EBCDIC-FR")
+	if _, e := prop.GetCharset(KEY); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesMergeReportReturnsConflicts(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("same", VALUE)
+	prop.Set("conflict", "old")
+	prop.Set("mine", VALUE)
+	other := setUpTestInstance()
+	other.Set("same", VALUE)
+	other.Set("conflict", "new")
+	other.Set("theirs", VALUE)
+	conflicts := prop.MergeReport(other)
+	if len(conflicts) != 1 || conflicts["conflict"] != [2]string{"old", "new"} {
+		t.Fatalf("Unexpected conflicts: %q", conflicts)
+	}
+	assertGetExpected(t, prop, "conflict", "new")
+	assertGetExpected(t, prop, "mine", VALUE)
+	assertGetExpected(t, prop, "theirs", VALUE)
+}
+
+func TestPropertiesGetTokensSplitsOnCommasAndWhitespace(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, " auth, logging  metrics,,\ttracing ,")
+	expected := []string{"auth", "logging", "metrics", "tracing"}
+	if got, present := prop.GetTokens(KEY); !present || !slices.Equal(got, expected) {
+		t.Fatalf("Expected: %q; got %q", expected, got)
+	}
+}
+
+func TestPropertiesGetTokensReturnsAbsentOnMissingKey(t *testing.T) {
+	prop := setUpTestInstance()
+	if got, present := prop.GetTokens(KEY); present || got != nil {
+		t.Fatalf("Expected: absent; got %q", got)
+	}
+}
+
+func TestPropertiesStoreMarkdownTableEscapesAndSorts(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("b", "x|y")
+	prop.Set("a", `C:\dir`)
+	stringWriter := &strings.Builder{}
+	if e := prop.StoreMarkdownTable(stringWriter); e != nil {
+		t.Fatal(e)
+	}
+	expected := "| Key | Value |\n| --- | --- |\n| a | C:\\\\dir |\n| b | x\\|y |\n"
+	if stored := stringWriter.String(); stored != expected {
+		t.Fatalf("Expected: %q; got %q", expected, stored)
+	}
+}
+
+func TestPropertiesLoadAcceptsSeparatorInValueByDefault(t *testing.T) {
+	prop := setUpTestInstance()
+	loadFromString(t, prop, KEY+"=a=b")
+	assertGetExpected(t, prop, KEY, "a=b")
+}
+
+func TestPropertiesLoadForbidsSeparatorInValueWhenStrict(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.StrictValueSeparator = true
+	assertLoadReturnsError(t, prop, KEY+"=a=b")
+}
+
+func TestRoundTripStoreThenLoadWhenStrict(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.StrictValueSeparator = true
+	prop.Set(KEY, "a=b")
+	prop2 := setUpTestInstance()
+	prop2.StrictValueSeparator = true
+	loadFromString(t, prop2, storeToString(t, prop))
+	assertGetExpected(t, prop2, KEY, "a=b")
+}
+
+func TestLoadIntoFillsGivenMap(t *testing.T) {
+	m := map[string]string{KEY: "old value", "other key": VALUE}
+	if e := LoadInto(m, strings.NewReader(REPR)); e != nil {
+		t.Fatal(e)
+	}
+	if m[KEY] != VALUE || m["other key"] != VALUE {
+		t.Fatalf("Unexpected map content: %q", m)
+	}
+}
+
+func TestLoadIntoFailsOnNilMap(t *testing.T) {
+	if e := LoadInto(nil, strings.NewReader(REPR)); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesGetMillisReturnsDuration(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "1500")
+	if got, e := prop.GetMillis(KEY); e != nil || got != 1500*time.Millisecond {
+		t.Fatalf("Expected: 1.5s; got %v (error: %v)", got, e)
+	}
+}
+
+func TestPropertiesGetSecondsReturnsDuration(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "3600")
+	if got, e := prop.GetSeconds(KEY); e != nil || got != time.Hour {
+		t.Fatalf("Expected: 1h; got %v (error: %v)", got, e)
+	}
+}
+
+func TestPropertiesGetSecondsFailsOnMalformedValue(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, "30s")
+	if _, e := prop.GetSeconds(KEY); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func TestPropertiesSetStoresSentinelLiterallyByDefault(t *testing.T) {
+	assertSetAndGetBackSame(t, KEY, DefaultUnsetSentinel)
+}
+
+func TestPropertiesLoadUnsetsPropertyOnSentinel(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.UnsetSentinel = DefaultUnsetSentinel
+	prop.Set(KEY, VALUE)
+	loadFromString(t, prop, KEY+"="+DefaultUnsetSentinel)
+	assertGetAbsent(t, prop, KEY)
+}
+
+func TestPropertiesCommonPrefixStopsAtSegmentBoundary(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("myapp.db.host", VALUE)
+	prop.Set("myapp.db.port", VALUE)
+	prop.Set("myapp.dbx", VALUE)
+	if got := prop.CommonPrefix(); got != "myapp." {
+		t.Fatalf("Expected: %q; got %q", "myapp.", got)
+	}
+}
+
+func TestPropertiesCommonPrefixOfSingleKey(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("myapp.db.host", VALUE)
+	if got := prop.CommonPrefix(); got != "myapp.db." {
+		t.Fatalf("Expected: %q; got %q", "myapp.db.", got)
+	}
+}
+
+func TestPropertiesCommonPrefixIsEmptyForDivergingKeys(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("myapp.db.host", VALUE)
+	prop.Set("other.db.host", VALUE)
+	if got := prop.CommonPrefix(); got != "" {
+		t.Fatalf("Expected: empty prefix; got %q", got)
+	}
+}
+
+func TestPropertiesStoreReturnsWriterError(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, VALUE)
+	if e := prop.Store(failingWriter{}); e == nil {
+		t.Fatal("Expected failure, but no error was raised")
+	}
+}
+
+func BenchmarkPropertiesStoreToFile(b *testing.B) {
+	prop := setUpTestInstance()
+	for i := range 10000 {
+		prop.Set(KEY+strconv.Itoa(i), VALUE+strconv.Itoa(i))
+	}
+	file, e := os.Create(filepath.Join(b.TempDir(), "bench.properties"))
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer file.Close()
+	for b.Loop() {
+		if e := prop.Store(file); e != nil {
+			b.Fatal(e)
+		}
+	}
+}
+
+func TestPropertiesKeysByValuePrefixReturnsSortedKeys(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set("b", "https://old.example.com/api")
+	prop.Set("a", "https://old.example.com")
+	prop.Set("c", "https://new.example.com")
+	expected := []string{"a", "b"}
+	if got := prop.KeysByValuePrefix("https://old.example.com"); !slices.Equal(got, expected) {
+		t.Fatalf("Expected: %q; got %q", expected, got)
+	}
+}
+
+func TestPropertiesStoreWrappedFailsOnNonPositiveWidth(t *testing.T) {
+	prop := setUpTestInstance()
+	prop.Set(KEY, VALUE)
+	for _, width := range []int{0, -3} {
+		if e := prop.StoreWrapped(&strings.Builder{}, width); e == nil {
+			t.Fatalf("Expected failure for width %d, but no error was raised", width)
+		}
+	}
+}
+
+func TestPropertiesGetPercentFailsOnNonFiniteNumbers(t *testing.T) {
+	prop := setUpTestInstance()
+	for _, value := range []string{"NaN%", "Inf%", "-Infinity%", "0x1p-2%"} {
+		prop.Set(KEY, value)
+		if _, e := prop.GetPercent(KEY); e == nil {
+			t.Fatalf("Expected failure for %q, but no error was raised", value)
+		}
+	}
+}